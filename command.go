@@ -0,0 +1,61 @@
+package raft
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Command represents an action to be taken on the replicated state machine.
+type Command interface {
+	CommandName() string
+}
+
+// CommandApply is implemented by commands that know how to apply themselves
+// to the server's state machine once their log entry has committed.
+type CommandApply interface {
+	Apply(Context) (interface{}, error)
+}
+
+var commandTypes = map[string]reflect.Type{}
+
+// RegisterCommand registers a command type so that it can be reconstructed
+// by name when log entries are read back off disk. Every Command that is
+// ever passed to Server.Do must be registered exactly once, typically from
+// an init() function in the package that defines it.
+func RegisterCommand(command Command) {
+	if _, ok := commandTypes[command.CommandName()]; ok {
+		panic(fmt.Sprintf("raft: duplicate command type registered for %s", command.CommandName()))
+	}
+	t := reflect.TypeOf(command)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	commandTypes[command.CommandName()] = t
+}
+
+// newCommand creates a new, zeroed instance of the command type previously
+// registered under name.
+func newCommand(name string) (Command, error) {
+	t, ok := commandTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("raft: unregistered command type: %s", name)
+	}
+	return reflect.New(t).Interface().(Command), nil
+}
+
+// NOPCommand is appended by a newly elected leader so that it can commit an
+// entry from its own term before serving reads, per the Raft paper section
+// 5.4.2.
+type NOPCommand struct{}
+
+func (c NOPCommand) CommandName() string {
+	return "raft:nop"
+}
+
+func (c NOPCommand) Apply(context Context) (interface{}, error) {
+	return nil, nil
+}
+
+func init() {
+	RegisterCommand(&NOPCommand{})
+}
@@ -0,0 +1,33 @@
+package raft
+
+// Context is passed into a CommandApply's Apply method so that it can
+// inspect the server state at the point the command is being applied.
+type Context interface {
+	Server() Server
+	CurrentTerm() uint64
+	CurrentIndex() uint64
+	CommitIndex() uint64
+}
+
+type context struct {
+	server       Server
+	currentTerm  uint64
+	currentIndex uint64
+	commitIndex  uint64
+}
+
+func (c *context) Server() Server {
+	return c.server
+}
+
+func (c *context) CurrentTerm() uint64 {
+	return c.currentTerm
+}
+
+func (c *context) CurrentIndex() uint64 {
+	return c.currentIndex
+}
+
+func (c *context) CommitIndex() uint64 {
+	return c.commitIndex
+}
@@ -0,0 +1,38 @@
+package raft
+
+import "errors"
+
+var (
+	// ErrCompacted is returned by a Storage's Entries/Term methods when the
+	// requested index has already been discarded by a prior compaction.
+	ErrCompacted = errors.New("raft: requested index is unavailable due to compaction")
+
+	// ErrUnavailable is returned when the requested entries are past the
+	// end of what the log currently knows about (the caller has gotten
+	// ahead of this node, e.g. a stale prevLogIndex from before a leader
+	// change or truncation).
+	ErrUnavailable = errors.New("raft: requested entry is unavailable")
+
+	// ErrSnapshotOutOfDate describes an InstallSnapshot RPC carrying a
+	// snapshot that is no longer newer than the state already applied.
+	// Nothing in this package returns it: Server.RequestSnapshot declines
+	// such a snapshot by answering Success false.
+	ErrSnapshotOutOfDate = errors.New("raft: snapshot is out of date")
+
+	// ErrStopped is returned by Server.Do when a command is submitted to a
+	// server that has already stopped.
+	ErrStopped = errors.New("raft: server has been stopped")
+
+	// ErrNotLeader is returned by Server.Do when a command is submitted to a
+	// server that is not currently the cluster leader.
+	ErrNotLeader = errors.New("raft: not current leader")
+
+	// ErrCommandTimeout is returned when a command fails to commit within
+	// the configured timeout.
+	ErrCommandTimeout = errors.New("raft: command timeout")
+
+	// ErrAlreadyRunning is returned by Server.ForceNewCluster when the
+	// server has already been started; it must be called instead of
+	// Start, on a server that is Stopped or Initialized.
+	ErrAlreadyRunning = errors.New("raft: server already running")
+)
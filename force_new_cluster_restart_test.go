@@ -0,0 +1,91 @@
+package raft
+
+import (
+	"os"
+	"testing"
+)
+
+// TestForceNewClusterAfterRestartUsesRestoredCommitIndex guards against a
+// stale recovery point: ForceNewCluster derives where to recover from
+// s.log.getCommitIndex(), but that was never restored from persisted hard
+// state on startup, only ever set by HardState.CommitIndex while a server
+// stayed up. A server that restarted before calling ForceNewCluster would
+// see a commit index of 0 and roll back past everything it had already
+// committed. This test persists a commit index, fully closes and reopens
+// the server (rather than setting the field by hand), and checks
+// ForceNewCluster recovers at the index that survived the restart.
+func TestForceNewClusterAfterRestartUsesRestoredCommitIndex(t *testing.T) {
+	path := getLogPath()
+	defer os.Remove(path)
+	defer os.Remove(path + ".snapshot")
+	defer os.Remove(path + ".state")
+
+	sm := &testStateMachine{}
+	srv, err := NewServer("node1", path, nil, sm, "")
+	if err != nil {
+		t.Fatalf("Unable to create server: %v", err)
+	}
+	s := srv.(*server)
+
+	for i := uint64(1); i <= 20; i++ {
+		entry, err := newLogEntry(s.log, nil, i, 1, &testCommand2{X: int(i)})
+		if err != nil {
+			t.Fatalf("Unable to build entry %d: %v", i, err)
+		}
+		if err := s.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+	if err := s.log.setCommitIndex(20); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	// An uncommitted tail this node received but the old leader never
+	// confirmed, exactly like TestForceNewClusterRecoversStandalone's
+	// in-process scenario, except here it's left behind across a real
+	// restart rather than inspected in the same process.
+	for i := uint64(21); i <= 25; i++ {
+		entry, err := newLogEntry(s.log, nil, i, 1, &testCommand2{X: int(i)})
+		if err != nil {
+			t.Fatalf("Unable to build entry %d: %v", i, err)
+		}
+		if err := s.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+	if err := s.log.close(); err != nil {
+		t.Fatalf("Unable to close log: %v", err)
+	}
+
+	srv2, err := NewServer("node1", path, nil, sm, "")
+	if err != nil {
+		t.Fatalf("Unable to reopen server: %v", err)
+	}
+	s2 := srv2.(*server)
+
+	if got := s2.log.getCommitIndex(); got != 20 {
+		t.Fatalf("expected the restored commit index to be 20, got %d", got)
+	}
+
+	if err := s2.ForceNewCluster(); err != nil {
+		t.Fatalf("ForceNewCluster failed: %v", err)
+	}
+	defer s2.Stop()
+
+	// becomeLeader commits one NOPCommand on top of the recovered index
+	// (20), and the uncommitted tail (21-25) must not have survived.
+	if got := s2.log.currentIndex(); got != 21 {
+		t.Fatalf("expected recovery at the restored commit index with the post-restart tail discarded, got current index %d", got)
+	}
+	if got := s2.log.getCommitIndex(); got != 21 {
+		t.Fatalf("expected commit index 21, got %d", got)
+	}
+
+	snap, err := readSnapshotFile(path + ".snapshot")
+	if err != nil {
+		t.Fatalf("Unable to read recovered snapshot: %v", err)
+	}
+	if snap.Metadata.Index != 20 {
+		t.Fatalf("expected the snapshot to be rooted at the restored commit index 20, got %d", snap.Metadata.Index)
+	}
+}
@@ -0,0 +1,102 @@
+package raft
+
+import (
+	"os"
+	"testing"
+)
+
+// TestForceNewClusterRecoversStandalone builds up a log that looks like it
+// came from a 3-member cluster (membership plus some committed and some
+// merely-replicated-but-uncommitted entries), then simulates losing the
+// other two members permanently: ForceNewCluster must bootstrap this node
+// as the leader of a new, single-member cluster rooted at the last
+// committed index, with the uncommitted tail and the old membership gone.
+func TestForceNewClusterRecoversStandalone(t *testing.T) {
+	path := getLogPath()
+	defer os.Remove(path)
+	defer os.Remove(path + ".snapshot")
+
+	sm := &testStateMachine{Count: 42}
+
+	srv, err := NewServer("node1", path, nil, sm, "")
+	if err != nil {
+		t.Fatalf("Unable to create server: %v", err)
+	}
+	s := srv.(*server)
+	defer s.log.close()
+
+	// Membership as it stood in the old 3-member cluster.
+	if err := s.AddPeer("node2", "node2"); err != nil {
+		t.Fatalf("Unable to add node2: %v", err)
+	}
+	if err := s.AddPeer("node3", "node3"); err != nil {
+		t.Fatalf("Unable to add node3: %v", err)
+	}
+
+	// Committed entries from the old cluster.
+	for i := uint64(1); i <= 10; i++ {
+		entry, err := newLogEntry(s.log, nil, i, 1, &testCommand2{X: int(i)})
+		if err != nil {
+			t.Fatalf("Unable to build entry %d: %v", i, err)
+		}
+		if err := s.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+	if err := s.log.setCommitIndex(10); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	// A tail this node received from the old leader but that never
+	// committed (e.g. the old leader died mid-replication).
+	for i := uint64(11); i <= 15; i++ {
+		entry, err := newLogEntry(s.log, nil, i, 1, &testCommand2{X: int(i)})
+		if err != nil {
+			t.Fatalf("Unable to build entry %d: %v", i, err)
+		}
+		if err := s.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+
+	if err := s.ForceNewCluster(); err != nil {
+		t.Fatalf("ForceNewCluster failed: %v", err)
+	}
+	defer s.Stop()
+
+	if got := s.State(); got != Leader {
+		t.Fatalf("expected node to become Leader, got %s", got)
+	}
+	if peers := s.Peers(); len(peers) != 0 {
+		t.Fatalf("expected every old peer to be discarded, got %v", peers)
+	}
+
+	// becomeLeader commits one NOPCommand from the new term on top of the
+	// recovered index (10), and the uncommitted tail (11-15) must not
+	// have survived into it.
+	if got := s.log.currentIndex(); got != 11 {
+		t.Fatalf("expected the uncommitted tail to be discarded and a NOP committed at 11, got %d", got)
+	}
+	if got := s.log.getCommitIndex(); got != 11 {
+		t.Fatalf("expected commit index 11, got %d", got)
+	}
+
+	snap, err := readSnapshotFile(path + ".snapshot")
+	if err != nil {
+		t.Fatalf("Unable to read recovered snapshot: %v", err)
+	}
+	if len(snap.Metadata.ConfState.Peers) != 1 || snap.Metadata.ConfState.Peers[0].Name != "node1" {
+		t.Fatalf("expected ConfState to name only node1, got %v", snap.Metadata.ConfState.Peers)
+	}
+	if snap.Metadata.Index != 10 {
+		t.Fatalf("expected the snapshot to be rooted at the last committed index 10, got %d", snap.Metadata.Index)
+	}
+
+	recovered := &testStateMachine{}
+	if err := recovered.Recovery(snap.Data); err != nil {
+		t.Fatalf("Unable to recover state machine from snapshot: %v", err)
+	}
+	if recovered.Count != 42 {
+		t.Fatalf("expected recovered state machine Count 42, got %d", recovered.Count)
+	}
+}
@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Log is the replication engine's view onto a Storage: it tracks the
+// highest committed index and, as that index advances, invokes ApplyFunc
+// for each newly committed entry in order. All actual persistence of
+// entries, snapshots and hard state is delegated to the underlying
+// Storage; Server and Peer only ever go through a *Log, which is what lets
+// a caller swap in its own backend via NewServerWithStorage.
+type Log struct {
+	// ApplyFunc receives the commit index as of the entry it's applying,
+	// rather than reading it back via getCommitIndex, since it's invoked
+	// while setCommitIndex still holds l.mutex.
+	ApplyFunc func(*LogEntry, Command, uint64) (interface{}, error)
+
+	mutex       sync.RWMutex
+	storage     Storage
+	commitIndex uint64
+}
+
+// newLog wraps storage in a Log, restoring the commit index from its
+// persisted hard state or, if higher, the index covered by its most
+// recent snapshot (entries up to there are committed by construction).
+func newLog(storage Storage) (*Log, error) {
+	l := &Log{storage: storage}
+
+	hs, err := storage.InitialState()
+	if err != nil {
+		return nil, err
+	}
+	l.commitIndex = hs.CommitIndex
+
+	snap, err := storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snap.Metadata.Index > l.commitIndex {
+		l.commitIndex = snap.Metadata.Index
+	}
+
+	return l, nil
+}
+
+// newFileLog wraps a file-backed Storage rooted at path in a Log. This is
+// what NewServer uses by default.
+func newFileLog(path string) (*Log, error) {
+	storage, err := newFileStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	return newLog(storage)
+}
+
+// close releases any resources the underlying storage holds open (e.g. a
+// file-backed Storage's file handle). Storage implementations that don't
+// need this, such as MemoryStorage, simply aren't asked.
+func (l *Log) close() error {
+	if closer, ok := l.storage.(interface{ close() error }); ok {
+		return closer.close()
+	}
+	return nil
+}
+
+// currentIndex returns the index of the most recently appended entry, or
+// the base index of the last compaction/snapshot if nothing has been
+// appended since.
+func (l *Log) currentIndex() uint64 {
+	last, err := l.storage.LastIndex()
+	if err != nil {
+		return 0
+	}
+	return last
+}
+
+// currentTerm returns the term of the most recently appended entry.
+func (l *Log) currentTerm() uint64 {
+	term, err := l.storage.Term(l.currentIndex())
+	if err != nil {
+		return 0
+	}
+	return term
+}
+
+// getCommitIndex returns the highest committed index.
+func (l *Log) getCommitIndex() uint64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.commitIndex
+}
+
+// setCommitIndex advances the log's commit index and applies every newly
+// committed entry via ApplyFunc, in order, exactly once each.
+func (l *Log) setCommitIndex(index uint64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if index < l.commitIndex {
+		return nil
+	}
+
+	last, err := l.storage.LastIndex()
+	if err != nil {
+		return err
+	}
+	if index > last {
+		return fmt.Errorf("raft.Log: Commit index (%d) is beyond current index (%d)", index, last)
+	}
+
+	entries, err := l.storage.Entries(l.commitIndex+1, index+1, 0)
+	if err != nil && err != ErrCompacted {
+		return err
+	}
+	l.applyEntries(entries)
+
+	l.commitIndex = index
+	return l.persistCommitIndexLocked()
+}
+
+// applyEntries invokes ApplyFunc for each entry in order, resolving any
+// pending Do() call waiting on it. Callers must hold l.mutex.
+func (l *Log) applyEntries(entries []*LogEntry) {
+	for _, entry := range entries {
+		if l.ApplyFunc == nil {
+			continue
+		}
+		result, err := l.ApplyFunc(entry, entry.Command, entry.Index)
+		if entry.event != nil {
+			entry.event.result = result
+			entry.event.c <- err
+		}
+	}
+}
+
+// persistCommitIndexLocked writes the log's current commit index into
+// HardState, preserving whatever term and vote are already persisted.
+// Callers must hold l.mutex. Without this, a commit index advanced between
+// term changes (the only other times HardState is written are
+// Server.setCurrentTerm and Server.ForceNewCluster) would not survive a
+// restart even though the entries it covers do, so newLog would restore a
+// stale commit index.
+func (l *Log) persistCommitIndexLocked() error {
+	hs, err := l.storage.InitialState()
+	if err != nil {
+		return err
+	}
+	hs.CommitIndex = l.commitIndex
+	return l.storage.SetHardState(hs)
+}
+
+// replayCommitted applies every entry between the log's snapshot base and
+// its restored commit index through ApplyFunc, in order. NewServerWithStorage
+// calls this once at startup, after the state machine has already been
+// recovered from the snapshot itself, so that entries committed before a
+// restart but not covered by the snapshot aren't silently dropped from the
+// state machine.
+func (l *Log) replayCommitted() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snap, err := l.storage.Snapshot()
+	if err != nil {
+		return err
+	}
+	if l.commitIndex <= snap.Metadata.Index {
+		return nil
+	}
+
+	entries, err := l.storage.Entries(snap.Metadata.Index+1, l.commitIndex+1, 0)
+	if err != nil && err != ErrCompacted {
+		return err
+	}
+	l.applyEntries(entries)
+	return nil
+}
+
+// appendEntry appends a single entry to the end of the log.
+func (l *Log) appendEntry(entry *LogEntry) error {
+	return l.storage.Append([]*LogEntry{entry})
+}
+
+// --- Storage interface -----------------------------------------------------
+//
+// The methods below let a *Log stand in directly as a Storage (e.g. for
+// Server.Storage()), simply delegating to whatever Storage it wraps. See
+// storage.go for the interface definition, and fileStorage/MemoryStorage
+// for the two implementations this package ships.
+
+func (l *Log) InitialState() (HardState, error) {
+	return l.storage.InitialState()
+}
+
+func (l *Log) SetHardState(hs HardState) error {
+	return l.storage.SetHardState(hs)
+}
+
+func (l *Log) Term(i uint64) (uint64, error) {
+	return l.storage.Term(i)
+}
+
+func (l *Log) Entries(lo, hi, maxSize uint64) ([]*LogEntry, error) {
+	return l.storage.Entries(lo, hi, maxSize)
+}
+
+func (l *Log) LastIndex() (uint64, error) {
+	return l.storage.LastIndex()
+}
+
+func (l *Log) FirstIndex() (uint64, error) {
+	return l.storage.FirstIndex()
+}
+
+func (l *Log) Snapshot() (Snapshot, error) {
+	return l.storage.Snapshot()
+}
+
+func (l *Log) ApplySnapshot(snap Snapshot) error {
+	if err := l.storage.ApplySnapshot(snap); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if snap.Metadata.Index > l.commitIndex {
+		l.commitIndex = snap.Metadata.Index
+		return l.persistCommitIndexLocked()
+	}
+	return nil
+}
+
+// Compact discards every entry up to and including snap.Metadata.Index,
+// preserving anything already held beyond it. Only committed entries may
+// be compacted.
+func (l *Log) Compact(snap Snapshot) error {
+	l.mutex.RLock()
+	commitIndex := l.commitIndex
+	l.mutex.RUnlock()
+
+	if snap.Metadata.Index > commitIndex {
+		return fmt.Errorf("raft.Log: Cannot compact past commit index")
+	}
+	return l.storage.Compact(snap)
+}
+
+func (l *Log) Append(entries []*LogEntry) error {
+	return l.storage.Append(entries)
+}
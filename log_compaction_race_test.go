@@ -5,28 +5,30 @@ import (
 	"testing"
 )
 
-// TestGetEntriesAfterIndexBeyondEnd reproduces the panic in issue #7810
+// TestLogEntriesIndexBeyondEnd reproduces the panic in issue #7810
 // https://github.com/seaweedfs/seaweedfs/issues/7810
 //
 // The issue occurs when:
 // 1. Leader sets peer's prevLogIndex to its current log index (e.g., 1000)
 // 2. Log compaction runs, removing entries and updating startIndex
-// 3. peer.flush() calls getEntriesAfter(prevLogIndex) with the stale index
+// 3. peer.flush() calls Term/Entries with the stale index
 // 4. The index is now beyond the end of the compacted log, causing a panic
 //
-// The expected behavior is to return nil (triggering snapshot fallback)
-// instead of panicking.
-func TestGetEntriesAfterIndexBeyondEnd(t *testing.T) {
+// The expected behavior is ErrUnavailable (or ErrCompacted, for an index
+// on the other side of startIndex) instead of a panic, so flush() can
+// recognize either as a signal to fall back to InstallSnapshot.
+func TestLogEntriesIndexBeyondEnd(t *testing.T) {
 	path := getLogPath()
-	log := newLog()
-	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
-		return nil, nil
-	}
-	if err := log.open(path); err != nil {
+	log, err := newFileLog(path)
+	if err != nil {
 		t.Fatalf("Unable to open log: %v", err)
 	}
+	log.ApplyFunc = func(e *LogEntry, c Command, commitIndex uint64) (interface{}, error) {
+		return nil, nil
+	}
 	defer log.close()
 	defer os.Remove(path)
+	defer os.Remove(path + ".snapshot")
 
 	// Create 1000 log entries
 	for i := uint64(1); i <= 1000; i++ {
@@ -48,7 +50,7 @@ func TestGetEntriesAfterIndexBeyondEnd(t *testing.T) {
 
 	// Compact the log - keep only entries after index 800
 	// This simulates what happens after a snapshot
-	if err := log.compact(800, 1); err != nil {
+	if err := log.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 800, Term: 1}}); err != nil {
 		t.Fatalf("Unable to compact: %v", err)
 	}
 
@@ -56,69 +58,79 @@ func TestGetEntriesAfterIndexBeyondEnd(t *testing.T) {
 	// - startIndex = 800
 	// - entries = [801, 802, ..., 1000] (200 entries)
 	// - max valid index = 800 + 200 = 1000
-	if log.startIndex != 800 {
-		t.Fatalf("Expected startIndex 800, got %d", log.startIndex)
+	if first, _ := log.FirstIndex(); first != 801 {
+		t.Fatalf("Expected first index 801, got %d", first)
 	}
-	if len(log.entries) != 200 {
-		t.Fatalf("Expected 200 entries, got %d", len(log.entries))
+	if log.currentIndex() != 1000 {
+		t.Fatalf("Expected current index still 1000, got %d", log.currentIndex())
 	}
 
-	// Test 1: Valid index within range should work
-	entries, term := log.getEntriesAfter(900, 100)
-	if entries == nil {
-		t.Fatalf("Expected entries for valid index 900")
+	// Test 1: Valid range within what's left should work
+	entries, err := log.Entries(901, 1001, 1<<30)
+	if err != nil {
+		t.Fatalf("Expected entries for valid range, got err=%v", err)
 	}
-	if term != 1 {
-		t.Fatalf("Expected term 1, got %d", term)
+	if len(entries) != 100 {
+		t.Fatalf("Expected 100 entries, got %d", len(entries))
 	}
 
-	// Test 2: Index at startIndex should return all entries (up to maxLogEntriesPerRequest)
-	entries, term = log.getEntriesAfter(800, 100)
-	if entries == nil {
-		t.Fatalf("Expected entries for startIndex")
+	// Test 2: maxSize bounds the batch by serialized bytes, not a fixed
+	// entry count: a small budget should return fewer entries than fit in
+	// the requested range, but never zero.
+	small := entries[0].size() * 3
+	limited, err := log.Entries(901, 1001, uint64(small))
+	if err != nil {
+		t.Fatalf("Expected entries for a small byte budget, got err=%v", err)
 	}
-	// When index == startIndex, it returns all entries (200), limited by max (100)
-	if len(entries) != 100 && len(entries) != 200 {
-		t.Fatalf("Expected 100-200 entries, got %d", len(entries))
+	if len(limited) == 0 || len(limited) >= len(entries) {
+		t.Fatalf("Expected the byte budget to cut the batch down, got %d of %d entries", len(limited), len(entries))
 	}
 
-	// Test 3: Index before startIndex should return nil (for snapshot fallback)
-	entries, term = log.getEntriesAfter(500, 100)
-	if entries != nil || term != 0 {
-		t.Fatalf("Expected nil for index before startIndex, got entries=%v term=%d", entries, term)
+	// Test 3: Requesting from before startIndex should report ErrCompacted.
+	if _, err := log.Term(500); err != ErrCompacted {
+		t.Fatalf("Expected ErrCompacted for index before startIndex, got %v", err)
+	}
+	if _, err := log.Entries(501, 1001, 1<<30); err != ErrCompacted {
+		t.Fatalf("Expected ErrCompacted for a range starting before startIndex, got %v", err)
 	}
 
-	// Test 4: Index beyond end of log should NOT panic
-	// This is the bug scenario from issue #7810
-	// The index 1050 could be a stale prevLogIndex from before compaction
+	// Test 4: Index/range beyond the end of the log should NOT panic.
+	// This is the bug scenario from issue #7810: 1050 could be a stale
+	// prevLogIndex from before compaction.
 	//
-	// Current behavior: PANICS with "raft: Index is beyond end of log: 200 1050"
-	// Expected behavior: Return nil to trigger snapshot fallback
+	// Previous behavior: PANICS with "raft: Index is beyond end of log: 200 1050"
+	// Expected behavior: ErrUnavailable, to trigger snapshot fallback.
 	defer func() {
 		if r := recover(); r != nil {
-			t.Fatalf("getEntriesAfter should not panic for index beyond log end: %v", r)
+			t.Fatalf("Term/Entries should not panic for an index beyond log end: %v", r)
 		}
 	}()
 
-	entries, term = log.getEntriesAfter(1050, 100)
-	if entries != nil || term != 0 {
-		t.Fatalf("Expected nil for index beyond log end, got entries=%v term=%d", entries, term)
+	if _, err := log.Term(1050); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable for index beyond log end, got %v", err)
+	}
+	if _, err := log.Entries(1001, 1101, 1<<30); err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable for a range beyond log end, got %v", err)
 	}
 }
 
-// TestGetEntriesAfterRaceWithCompaction simulates the race condition
-// between peer.flush() and log compaction that causes issue #7810
-func TestGetEntriesAfterRaceWithCompaction(t *testing.T) {
+// TestLogEntriesRaceWithCompaction simulates the race condition between
+// peer.flush() and log compaction that causes issue #7810. It builds the
+// "stale prevLogIndex now beyond the compacted log" scenario entirely
+// through the public Log/Storage API, driving several rounds of append,
+// commit and compaction rather than reaching into private fields.
+func TestLogEntriesRaceWithCompaction(t *testing.T) {
 	path := getLogPath()
-	log := newLog()
-	log.ApplyFunc = func(e *LogEntry, c Command) (interface{}, error) {
-		return nil, nil
-	}
-	if err := log.open(path); err != nil {
+	log, err := newFileLog(path)
+	if err != nil {
 		t.Fatalf("Unable to open log: %v", err)
 	}
+	log.ApplyFunc = func(e *LogEntry, c Command, commitIndex uint64) (interface{}, error) {
+		return nil, nil
+	}
 	defer log.close()
 	defer os.Remove(path)
+	defer os.Remove(path + ".snapshot")
 
 	// Create a log with entries
 	for i := uint64(1); i <= 500; i++ {
@@ -138,7 +150,7 @@ func TestGetEntriesAfterRaceWithCompaction(t *testing.T) {
 
 	// Simulate: Snapshot/compaction runs, keeping only last 50 entries
 	// After this: startIndex=450, entries=[451..500], max index=500
-	if err := log.compact(450, 1); err != nil {
+	if err := log.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 450, Term: 1}}); err != nil {
 		t.Fatalf("Unable to compact: %v", err)
 	}
 
@@ -155,13 +167,16 @@ func TestGetEntriesAfterRaceWithCompaction(t *testing.T) {
 	if err := log.setCommitIndex(550); err != nil {
 		t.Fatalf("Unable to commit: %v", err)
 	}
-	if err := log.compact(540, 1); err != nil {
+	if err := log.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 540, Term: 1}}); err != nil {
 		t.Fatalf("Unable to compact: %v", err)
 	}
 
-	// Now the peer's prevLogIndex (500) is between startIndex (540) and max (550)
-	// Actually, 500 < 540, so it would return nil (correct behavior)
-	
+	// Now the peer's prevLogIndex (500) is before startIndex (540), so it
+	// would report ErrCompacted (correct behavior).
+	if _, err := log.Term(prevLogIndex); err != ErrCompacted {
+		t.Fatalf("Expected ErrCompacted for prevLogIndex=%d, got %v", prevLogIndex, err)
+	}
+
 	// But what if prevLogIndex was updated to something in between compactions?
 	// Simulate: prevLogIndex was set to 545 during first compaction window
 	prevLogIndex = 545
@@ -178,42 +193,37 @@ func TestGetEntriesAfterRaceWithCompaction(t *testing.T) {
 	}
 	// Compact to only keep last 5 entries
 	// After: startIndex=595, entries=[596..600], max=600
-	if err := log.compact(595, 1); err != nil {
+	if err := log.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 595, Term: 1}}); err != nil {
 		t.Fatalf("Unable to compact: %v", err)
 	}
 
-	// Now prevLogIndex=545 is:
-	// - Greater than startIndex (595)? No, 545 < 595
-	// So it would return nil (correct)
-
-	// The real problematic case is when prevLogIndex > startIndex but > max
-	// This can happen if:
-	// 1. prevLogIndex is set to current log end (e.g., 600)
-	// 2. Log gets truncated (not compacted) due to leader change
-	// 3. New log has fewer entries
-
-	// Simulate this by manually setting startIndex to create the condition
-	log.mutex.Lock()
-	log.startIndex = 590
-	log.entries = log.entries[5:] // Only keep last 5 entries [596..600]
-	log.mutex.Unlock()
+	// Now prevLogIndex=545 is before startIndex (595), so ErrCompacted
+	// (correct behavior).
+	if _, err := log.Term(prevLogIndex); err != ErrCompacted {
+		t.Fatalf("Expected ErrCompacted for prevLogIndex=%d, got %v", prevLogIndex, err)
+	}
 
-	// Now: startIndex=590, entries=5, max valid=595
-	// But we call with prevLogIndex=600
-	prevLogIndex = 600
+	// The real problematic case is when prevLogIndex > startIndex but >
+	// max. Reach it legitimately: commit and compact up to the log's
+	// current end (600), then ask about an index just past it (600+N),
+	// exactly like a prevLogIndex recorded right before a burst of
+	// compactions that left the log shorter than the peer last saw.
+	if err := log.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 600, Term: 1}}); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+	prevLogIndex = 605
 
-	// This should trigger the panic in the current code
+	// This should report ErrUnavailable, not panic.
 	defer func() {
 		if r := recover(); r != nil {
-			t.Fatalf("getEntriesAfter panicked for index beyond log end: %v\n"+
-				"This is bug #7810 - should return nil for snapshot fallback instead", r)
+			t.Fatalf("Term panicked for index beyond log end: %v\n"+
+				"This is bug #7810 - should report ErrUnavailable for snapshot fallback instead", r)
 		}
 	}()
 
-	entries, term := log.getEntriesAfter(prevLogIndex, 100)
-	if entries != nil {
-		t.Fatalf("Expected nil for index beyond log, got %d entries", len(entries))
+	_, err = log.Term(prevLogIndex)
+	if err != ErrUnavailable {
+		t.Fatalf("Expected ErrUnavailable for index beyond log end, got %v", err)
 	}
-	t.Logf("Correctly returned nil, term=%d for index beyond log end", term)
+	t.Logf("Correctly reported ErrUnavailable for index beyond log end")
 }
-
@@ -0,0 +1,125 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// LogEntry is a single entry in a server's replicated log: a Command bound
+// to the term and index it was proposed at.
+type LogEntry struct {
+	log         *Log
+	Index       uint64
+	Term        uint64
+	CommandName string
+	Command     Command
+
+	// commandData is the raw encoding of Command, kept around so entries
+	// read back from disk can be re-serialized without re-marshaling.
+	commandData []byte
+
+	// event, if non-nil, is signaled once this entry commits, waking up
+	// whatever Server.Do call is blocked waiting on it.
+	event *ev
+}
+
+// entryEnvelope is the on-disk/on-wire JSON representation of a LogEntry.
+type entryEnvelope struct {
+	Index       uint64          `json:"index"`
+	Term        uint64          `json:"term"`
+	CommandName string          `json:"commandName,omitempty"`
+	Command     json.RawMessage `json:"command,omitempty"`
+}
+
+func newLogEntry(log *Log, event *ev, index uint64, term uint64, command Command) (*LogEntry, error) {
+	entry := &LogEntry{
+		log:   log,
+		Index: index,
+		Term:  term,
+		event: event,
+	}
+
+	if command != nil {
+		entry.CommandName = command.CommandName()
+		entry.Command = command
+
+		data, err := json.Marshal(command)
+		if err != nil {
+			return nil, err
+		}
+		entry.commandData = data
+	}
+
+	return entry, nil
+}
+
+// size returns an approximation of how many bytes this entry occupies on
+// the wire/on disk, used to bound the size of AppendEntries batches.
+func (e *LogEntry) size() int {
+	// index + term + overhead for the envelope, plus the command payload.
+	return 16 + len(e.CommandName) + len(e.commandData)
+}
+
+// encode writes entry as a single length-prefixed JSON line to w, returning
+// the number of bytes written.
+func (e *LogEntry) encode(w *bufio.Writer) (int, error) {
+	env := entryEnvelope{
+		Index:       e.Index,
+		Term:        e.Term,
+		CommandName: e.CommandName,
+		Command:     json.RawMessage(e.commandData),
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := fmt.Fprintf(w, "%d %s\n", len(data), data)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// decode reads one length-prefixed JSON line from r into entry.
+func (e *LogEntry) decode(r *bufio.Reader) (int, error) {
+	var length int
+	n, err := fmt.Fscanf(r, "%d ", &length)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, length+1)
+	read, err := io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+
+	var env entryEnvelope
+	if err := json.Unmarshal(buf[:length], &env); err != nil {
+		return 0, err
+	}
+
+	e.Index = env.Index
+	e.Term = env.Term
+	e.CommandName = env.CommandName
+	e.commandData = []byte(env.Command)
+
+	if e.CommandName != "" {
+		cmd, err := newCommand(e.CommandName)
+		if err != nil {
+			return 0, err
+		}
+		if len(env.Command) > 0 {
+			if err := json.Unmarshal(env.Command, cmd); err != nil {
+				return 0, err
+			}
+		}
+		e.Command = cmd
+	}
+
+	return n + read, nil
+}
@@ -0,0 +1,35 @@
+package raft
+
+import "testing"
+
+// TestMemoryStorageEntriesBeyondCompactedEnd reproduces, purely through
+// MemoryStorage's public Storage API, the same "stale prevLogIndex now
+// beyond the compacted log" scenario TestLogEntriesRaceWithCompaction
+// exercises against the file-backed Log: a peer's prevLogIndex recorded
+// before a burst of compactions can end up past the log's new end, and
+// that must report ErrUnavailable rather than panic or silently succeed.
+// Nothing here reaches into a private field — the tail-preserving Compact
+// introduced for the snapshot/restart fix makes that unnecessary for any
+// Storage implementation, not just the file-backed one.
+func TestMemoryStorageEntriesBeyondCompactedEnd(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	for i := uint64(1); i <= 100; i++ {
+		if err := storage.Append([]*LogEntry{{Index: i, Term: 1}}); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+
+	prevLogIndex := uint64(100)
+
+	if err := storage.Compact(Snapshot{Metadata: SnapshotMetadata{Index: 100, Term: 1}}); err != nil {
+		t.Fatalf("Unable to compact: %v", err)
+	}
+
+	if _, err := storage.Term(prevLogIndex + 5); err != ErrUnavailable {
+		t.Fatalf("expected ErrUnavailable for an index beyond the compacted log's end, got %v", err)
+	}
+	if _, err := storage.Entries(prevLogIndex+1, prevLogIndex+6, 0); err != ErrUnavailable {
+		t.Fatalf("expected ErrUnavailable for a range beyond the compacted log's end, got %v", err)
+	}
+}
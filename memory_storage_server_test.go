@@ -0,0 +1,52 @@
+package raft
+
+import "testing"
+
+// TestServerWithMemoryStorage exercises NewServerWithStorage end-to-end
+// against MemoryStorage, exactly the kind of caller-supplied backend the
+// Storage interface exists to support: a single-member cluster submits a
+// command and the state machine sees it applied, with nothing ever
+// touching disk.
+func TestServerWithMemoryStorage(t *testing.T) {
+	sm := &testStateMachine{}
+	storage := NewMemoryStorage()
+
+	srv, err := NewServerWithStorage("node1", storage, nil, sm, "")
+	if err != nil {
+		t.Fatalf("Unable to create server: %v", err)
+	}
+	s := srv.(*server)
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Unable to start server: %v", err)
+	}
+	defer s.Stop()
+
+	s.setState(Leader)
+	s.mutex.Lock()
+	s.currentTerm = 1
+	s.leader = s.name
+	s.mutex.Unlock()
+
+	if _, err := s.Do(&testCommand2{X: 7}); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+
+	if got := s.log.currentIndex(); got != 1 {
+		t.Fatalf("expected a single entry to have been appended, got index %d", got)
+	}
+	if got := s.log.getCommitIndex(); got != 1 {
+		t.Fatalf("expected the entry to be committed immediately (no peers), got commit index %d", got)
+	}
+
+	entries, err := storage.Entries(1, 2, 0)
+	if err != nil {
+		t.Fatalf("Unable to read back entry from MemoryStorage: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected MemoryStorage to hold the appended entry, got %d entries", len(entries))
+	}
+	if cmd, ok := entries[0].Command.(*testCommand2); !ok || cmd.X != 7 {
+		t.Fatalf("expected MemoryStorage's entry to carry the submitted command, got %#v", entries[0].Command)
+	}
+}
@@ -0,0 +1,330 @@
+package raft
+
+import (
+	"sync"
+	"time"
+)
+
+// snapshotChunkSize bounds how many bytes of snapshot Data are sent in a
+// single InstallSnapshot RPC, so a large snapshot doesn't block a peer's
+// heartbeat goroutine (or blow past any RPC size limit the Transporter
+// enforces) for the whole transfer. Each flush sends at most one chunk,
+// spreading the transfer out across heartbeats.
+const snapshotChunkSize = 1 << 20 // 1MB
+
+// snapshotTransfer tracks an in-progress InstallSnapshot transfer to a
+// peer: which snapshot is being sent, and how much of it has been
+// acknowledged so far. Its presence on a Peer is what lets flush() resume
+// a transfer on the next heartbeat instead of restarting it from scratch,
+// and what stops a concurrent flush from starting a second, competing one.
+type snapshotTransfer struct {
+	snapshot Snapshot
+	offset   uint64
+}
+
+// Peer tracks a leader's view of a single other member of the cluster:
+// where it believes that member's log stands, and the heartbeat loop that
+// keeps it in sync.
+type Peer struct {
+	server            *server
+	Name              string
+	ConnectionString  string
+	prevLogIndex      uint64
+	matchIndex        uint64
+	snapshot          *snapshotTransfer
+	mutex             sync.RWMutex
+	stopChan          chan bool
+	heartbeatInterval time.Duration
+	lastActivity      time.Time
+}
+
+// newPeer creates a Peer for the given server, replicating at the given
+// heartbeat interval.
+func newPeer(server *server, name string, connectionString string, heartbeatInterval time.Duration) *Peer {
+	return &Peer{
+		server:            server,
+		Name:              name,
+		ConnectionString:  connectionString,
+		heartbeatInterval: heartbeatInterval,
+	}
+}
+
+// setHeartbeatInterval sets the amount of time between heartbeats.
+func (p *Peer) setHeartbeatInterval(duration time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.heartbeatInterval = duration
+}
+
+// LastActivity returns the last time this peer successfully responded to
+// an RPC.
+func (p *Peer) LastActivity() time.Time {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.lastActivity
+}
+
+func (p *Peer) setLastActivity(t time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.lastActivity = t
+}
+
+// clone returns a point-in-time copy of p, safe to read without holding any
+// lock, for callers (e.g. Server.Peers) that need a consistent snapshot of
+// peer state without racing the heartbeat goroutine.
+func (p *Peer) clone() *Peer {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return &Peer{
+		Name:             p.Name,
+		ConnectionString: p.ConnectionString,
+		prevLogIndex:     p.prevLogIndex,
+		matchIndex:       p.matchIndex,
+	}
+}
+
+// setPrevLogIndex records where the leader currently believes this peer's
+// log stands.
+func (p *Peer) setPrevLogIndex(value uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.prevLogIndex = value
+}
+
+func (p *Peer) getPrevLogIndex() uint64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.prevLogIndex
+}
+
+// setMatchIndex records the highest index this peer is now known to have
+// replicated, used by the leader to compute what's safe to commit.
+func (p *Peer) setMatchIndex(value uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if value > p.matchIndex {
+		p.matchIndex = value
+	}
+}
+
+func (p *Peer) getMatchIndex() uint64 {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.matchIndex
+}
+
+// hasSnapshotTransfer reports whether an InstallSnapshot transfer to this
+// peer is already under way.
+func (p *Peer) hasSnapshotTransfer() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.snapshot != nil
+}
+
+// startHeartbeat begins periodically flushing new entries (or a heartbeat)
+// to this peer until stopHeartbeat is called.
+func (p *Peer) startHeartbeat() {
+	p.mutex.Lock()
+	p.stopChan = make(chan bool)
+	c := p.stopChan
+	p.lastActivity = time.Now()
+	p.mutex.Unlock()
+
+	ticker := time.NewTicker(p.heartbeatInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c:
+				return
+			case <-ticker.C:
+				p.flush()
+			}
+		}
+	}()
+}
+
+// stopHeartbeat stops the heartbeat loop started by startHeartbeat.
+func (p *Peer) stopHeartbeat(flush bool) {
+	p.mutex.Lock()
+	if p.stopChan != nil {
+		close(p.stopChan)
+		p.stopChan = nil
+	}
+	p.mutex.Unlock()
+
+	if flush {
+		p.flush()
+	}
+}
+
+// flush sends this peer whatever it needs to catch up. If an InstallSnapshot
+// transfer is already in flight, it sends the next chunk; otherwise it
+// tries a normal AppendEntries batch, falling back to starting a snapshot
+// transfer when the leader's log has compacted past what this peer has, or
+// is otherwise too far behind (or too far ahead, e.g. after a leader
+// change truncated the log) for Storage.Entries to serve it.
+func (p *Peer) flush() {
+	s := p.server
+
+	if p.hasSnapshotTransfer() {
+		p.sendSnapshotChunk()
+		return
+	}
+
+	prevLogIndex := p.getPrevLogIndex()
+	prevLogTerm, err := s.log.Term(prevLogIndex)
+	if err != nil {
+		p.startSnapshotTransfer()
+		return
+	}
+
+	lastIndex, _ := s.log.LastIndex()
+	entries, err := s.log.Entries(prevLogIndex+1, lastIndex+1, s.maxAppendEntriesBytes)
+	if err != nil {
+		p.startSnapshotTransfer()
+		return
+	}
+	p.sendAppendEntriesRequest(entries, prevLogTerm)
+}
+
+func (p *Peer) sendAppendEntriesRequest(entries []*LogEntry, prevLogTerm uint64) {
+	s := p.server
+	req := &AppendEntriesRequest{
+		Term:         s.currentTerm,
+		PrevLogIndex: p.getPrevLogIndex(),
+		PrevLogTerm:  prevLogTerm,
+		CommitIndex:  s.log.getCommitIndex(),
+		LeaderName:   s.name,
+		Entries:      entries,
+	}
+
+	resp := s.transporter.SendAppendEntriesRequest(s, p, req)
+	if resp == nil {
+		return
+	}
+	p.setLastActivity(time.Now())
+
+	if resp.Success {
+		if len(entries) > 0 {
+			p.setPrevLogIndex(entries[len(entries)-1].Index)
+			p.setMatchIndex(entries[len(entries)-1].Index)
+			s.advanceCommitIndex()
+		}
+		return
+	}
+
+	if resp.Term > s.currentTerm {
+		s.setCurrentTerm(resp.Term, "", false)
+		return
+	}
+
+	// Follower rejected because of a log mismatch; back off by one and
+	// retry on the next heartbeat.
+	if p.getPrevLogIndex() > 0 {
+		p.setPrevLogIndex(p.getPrevLogIndex() - 1)
+	}
+}
+
+// startSnapshotTransfer asks the peer whether it is willing to receive a
+// snapshot and, if so, records a snapshotTransfer and sends the first
+// chunk. If a transfer is already in flight (e.g. a concurrent flush from
+// an immediate post-commit replication push raced the heartbeat ticker)
+// this is a no-op, so the two callers never start two competing transfers.
+func (p *Peer) startSnapshotTransfer() {
+	s := p.server
+
+	p.mutex.Lock()
+	if p.snapshot != nil {
+		p.mutex.Unlock()
+		return
+	}
+	p.mutex.Unlock()
+
+	snap, err := s.log.Snapshot()
+	if err != nil || snap.Metadata.Index == 0 {
+		return
+	}
+
+	req := &SnapshotRequest{
+		LeaderName: s.name,
+		LastIndex:  snap.Metadata.Index,
+		LastTerm:   snap.Metadata.Term,
+	}
+	resp := s.transporter.SendSnapshotRequest(s, p, req)
+	if resp == nil || !resp.Success {
+		return
+	}
+
+	p.mutex.Lock()
+	if p.snapshot == nil {
+		p.snapshot = &snapshotTransfer{snapshot: snap}
+	}
+	p.mutex.Unlock()
+
+	p.sendSnapshotChunk()
+}
+
+// sendSnapshotChunk sends the next unacknowledged chunk of the in-flight
+// snapshot transfer. Once the follower has acknowledged the final chunk,
+// the peer's prevLogIndex/matchIndex are reset to the snapshot's index and
+// the transfer is cleared so flush() resumes normal AppendEntries.
+func (p *Peer) sendSnapshotChunk() {
+	s := p.server
+
+	p.mutex.RLock()
+	transfer := p.snapshot
+	p.mutex.RUnlock()
+	if transfer == nil {
+		return
+	}
+
+	data := transfer.snapshot.Data
+	offset := transfer.offset
+	end := offset + snapshotChunkSize
+	done := false
+	if end >= uint64(len(data)) {
+		end = uint64(len(data))
+		done = true
+	}
+
+	req := &SnapshotRecoveryRequest{
+		LeaderName: s.name,
+		Metadata:   transfer.snapshot.Metadata,
+		Offset:     offset,
+		Data:       data[offset:end],
+		Done:       done,
+	}
+	resp := s.transporter.SendSnapshotRecoveryRequest(s, p, req)
+	if resp == nil || !resp.Success {
+		// Leave the transfer in place; the next heartbeat retries the same
+		// chunk instead of starting over.
+		return
+	}
+	p.setLastActivity(time.Now())
+
+	// The follower reports the offset it actually has, so a transfer that
+	// was already partially received (e.g. resumed after this leader
+	// restarted) picks up from there rather than re-sending bytes, and a
+	// chunk the follower did not take is sent again.
+	nextOffset := resp.NextOffset
+
+	if done && nextOffset >= uint64(len(data)) {
+		index := transfer.snapshot.Metadata.Index
+		p.mutex.Lock()
+		p.snapshot = nil
+		p.mutex.Unlock()
+
+		p.setPrevLogIndex(index)
+		p.setMatchIndex(index)
+		s.advanceCommitIndex()
+		return
+	}
+
+	p.mutex.Lock()
+	if p.snapshot != nil {
+		p.snapshot.offset = nextOffset
+	}
+	p.mutex.Unlock()
+}
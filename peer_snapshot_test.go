@@ -0,0 +1,110 @@
+package raft
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestPeerFlushFallsBackToSnapshotAfterCompaction reproduces the compaction
+// race from issue #7810 end-to-end: a peer that has never replicated
+// anything falls behind a leader whose log has since been compacted, so
+// Storage.Entries can no longer serve it. flush() must recognize this and
+// fall back to InstallSnapshot instead of repeatedly failing AppendEntries,
+// and the follower must end up caught up to the leader's committed state.
+func TestPeerFlushFallsBackToSnapshotAfterCompaction(t *testing.T) {
+	leaderPath := getLogPath()
+	followerPath := getLogPath()
+	defer os.Remove(leaderPath)
+	defer os.Remove(followerPath)
+	defer os.Remove(leaderPath + ".snapshot")
+	defer os.Remove(followerPath + ".snapshot")
+
+	leaderSM := &testStateMachine{Count: 1000}
+	followerSM := &testStateMachine{}
+
+	leaderSrv, err := NewServer("leader", leaderPath, nil, leaderSM, "")
+	if err != nil {
+		t.Fatalf("Unable to create leader: %v", err)
+	}
+	followerSrv, err := NewServer("follower", followerPath, nil, followerSM, "")
+	if err != nil {
+		t.Fatalf("Unable to create follower: %v", err)
+	}
+	defer leaderSrv.(*server).log.close()
+	defer followerSrv.(*server).log.close()
+
+	transporter := &fakeTransporter{servers: map[string]Server{
+		"leader":   leaderSrv,
+		"follower": followerSrv,
+	}}
+
+	ls := leaderSrv.(*server)
+	fs := followerSrv.(*server)
+	ls.transporter = transporter
+	fs.transporter = transporter
+	ls.state = Leader
+	ls.currentTerm = 1
+	fs.state = Follower
+
+	// Build up 1000 committed entries on the leader without ever having
+	// replicated any of them to the follower (prevLogIndex stays at its
+	// default, 0).
+	for i := uint64(1); i <= 1000; i++ {
+		entry, err := newLogEntry(ls.log, nil, i, 1, &testCommand2{X: int(i)})
+		if err != nil {
+			t.Fatalf("Unable to build entry %d: %v", i, err)
+		}
+		if err := ls.log.appendEntry(entry); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+	if err := ls.log.setCommitIndex(1000); err != nil {
+		t.Fatalf("Unable to commit: %v", err)
+	}
+
+	// Compacting discards everything the follower would need for a normal
+	// AppendEntries catch-up from index 0.
+	if err := ls.TakeSnapshot(); err != nil {
+		t.Fatalf("Unable to take snapshot: %v", err)
+	}
+
+	peer := newPeer(ls, "follower", "follower", time.Hour)
+	ls.peers["follower"] = peer
+
+	if _, err := ls.log.Term(peer.getPrevLogIndex()); err != ErrCompacted {
+		t.Fatalf("expected the follower's prevLogIndex to be reported ErrCompacted, got %v", err)
+	}
+
+	// A single flush should recognize the fallback, install the whole
+	// snapshot (it's small enough to fit in one chunk) and leave the peer
+	// caught up rather than looping on failed AppendEntries.
+	peer.flush()
+
+	if peer.hasSnapshotTransfer() {
+		t.Fatalf("expected the snapshot transfer to have completed in one chunk")
+	}
+	if got := peer.getPrevLogIndex(); got != 1000 {
+		t.Fatalf("expected peer.prevLogIndex to reset to 1000 after the snapshot, got %d", got)
+	}
+	if got := fs.log.currentIndex(); got != 1000 {
+		t.Fatalf("expected follower log to catch up to index 1000, got %d", got)
+	}
+	if got := fs.log.getCommitIndex(); got != 1000 {
+		t.Fatalf("expected follower commit index to be 1000, got %d", got)
+	}
+	if followerSM.Count != 1000 {
+		t.Fatalf("expected follower state machine to recover Count=1000, got %d", followerSM.Count)
+	}
+
+	// Now that the peer is caught up, normal replication resumes instead
+	// of continuing to fall back to snapshots.
+	if _, err := ls.Do(&testCommand2{X: 1001}); err != nil {
+		t.Fatalf("Do failed after snapshot catch-up: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	peer.flush()
+	if peer.hasSnapshotTransfer() {
+		t.Fatalf("expected a caught-up peer to replicate via AppendEntries, not a snapshot")
+	}
+}
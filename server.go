@@ -0,0 +1,746 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHeartbeatInterval is how often a leader pings its peers.
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+
+	// DefaultElectionTimeout is the base timeout a follower waits for
+	// leader activity before starting an election. The server randomizes
+	// around this to avoid split votes.
+	DefaultElectionTimeout = 150 * time.Millisecond
+
+	// maxAppendEntriesBytes bounds the total serialized size of the
+	// entries a single AppendEntries RPC may carry, so a batch is sized by
+	// byte budget rather than by a fixed entry count (which a handful of
+	// large commands could blow well past any RPC size limit the
+	// Transporter enforces).
+	maxAppendEntriesBytes = 1 << 20 // 1MB
+)
+
+// ev carries the outcome of applying a command back to whatever goroutine
+// called Server.Do: c is signaled once the entry commits, and result holds
+// whatever its Apply method returned, set before c is signaled so Do can
+// read it without racing.
+type ev struct {
+	c      chan error
+	result interface{}
+}
+
+// Server is the externally visible interface to a Raft node: submit
+// commands, inspect its role, and manage cluster membership.
+type Server interface {
+	Name() string
+	State() State
+	Leader() string
+	CurrentTerm() uint64
+	Peers() map[string]*Peer
+	Storage() Storage
+
+	Start() error
+	Stop()
+	ForceNewCluster() error
+
+	Do(command Command) (interface{}, error)
+
+	AddPeer(name string, connectionString string) error
+	RemovePeer(name string) error
+
+	RequestVote(req *RequestVoteRequest) *RequestVoteResponse
+	AppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse
+	RequestSnapshot(req *SnapshotRequest) *SnapshotResponse
+	SnapshotRecovery(req *SnapshotRecoveryRequest) *SnapshotRecoveryResponse
+
+	TakeSnapshot() error
+}
+
+// server is the default Server implementation.
+type server struct {
+	mutex sync.RWMutex
+
+	name        string
+	state       State
+	currentTerm uint64
+	votedFor    string
+	leader      string
+
+	log          *Log
+	transporter  Transporter
+	stateMachine StateMachine
+	context      string
+
+	peers map[string]*Peer
+
+	heartbeatInterval time.Duration
+	electionTimeout   time.Duration
+
+	maxAppendEntriesBytes uint64
+
+	// incoming tracks a SnapshotRecoveryRequest transfer in progress from
+	// the current leader, so chunks can be appended as they arrive and
+	// validated against replays/duplicates from an earlier attempt.
+	incoming *incomingSnapshot
+
+	stopChan chan bool
+}
+
+// incomingSnapshot accumulates the chunks of an InstallSnapshot transfer
+// this server is currently receiving.
+type incomingSnapshot struct {
+	metadata SnapshotMetadata
+	data     []byte
+}
+
+// NewServer creates a Server named name, persisting its log at logPath,
+// communicating over transporter, and applying committed commands to
+// stateMachine (which may be nil).
+func NewServer(name string, logPath string, transporter Transporter, stateMachine StateMachine, context string) (Server, error) {
+	storage, err := newFileStorage(logPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewServerWithStorage(name, storage, transporter, stateMachine, context)
+}
+
+// NewServerWithStorage creates a Server exactly like NewServer, but backed
+// by storage instead of the default file-backed implementation — for
+// callers that want to supply their own durable backend (e.g. BoltDB,
+// LevelDB), or MemoryStorage in tests that don't need the log to survive a
+// process restart.
+//
+// If storage already holds committed state, it is fully recovered before
+// this returns: the state machine is restored from the most recent
+// snapshot, then every entry committed after it is replayed through
+// ApplyFunc, so the state machine ends up reflecting the same commit index
+// the restored log reports.
+func NewServerWithStorage(name string, storage Storage, transporter Transporter, stateMachine StateMachine, context string) (Server, error) {
+	if name == "" {
+		return nil, fmt.Errorf("raft.Server: Name cannot be blank")
+	}
+
+	log, err := newLog(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &server{
+		name:                  name,
+		state:                 Stopped,
+		log:                   log,
+		transporter:           transporter,
+		stateMachine:          stateMachine,
+		context:               context,
+		peers:                 make(map[string]*Peer),
+		heartbeatInterval:     DefaultHeartbeatInterval,
+		electionTimeout:       DefaultElectionTimeout,
+		maxAppendEntriesBytes: maxAppendEntriesBytes,
+	}
+
+	log.ApplyFunc = func(entry *LogEntry, command Command, commitIndex uint64) (interface{}, error) {
+		return s.applyCommand(entry, command, commitIndex)
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := log.replayCommitted(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadSnapshot recovers stateMachine from the log's most recently applied
+// snapshot, if any. It is a no-op if no snapshot has ever been taken, or if
+// this server has no state machine configured.
+func (s *server) loadSnapshot() error {
+	if s.stateMachine == nil {
+		return nil
+	}
+
+	snap, err := s.log.Snapshot()
+	if err != nil {
+		return err
+	}
+	if snap.Metadata.Index == 0 && snap.Data == nil {
+		return nil
+	}
+	return s.stateMachine.Recovery(snap.Data)
+}
+
+// applyCommand invokes command's Apply method, if it has one. commitIndex is
+// passed in by the caller rather than read back from the log: the usual
+// caller is Log.ApplyFunc, invoked while setCommitIndex still holds the
+// log's mutex, so calling back into s.log.getCommitIndex() here would
+// deadlock.
+func (s *server) applyCommand(entry *LogEntry, command Command, commitIndex uint64) (interface{}, error) {
+	applier, ok := command.(CommandApply)
+	if !ok {
+		return nil, nil
+	}
+	ctx := &context{
+		server:       s,
+		currentTerm:  entry.Term,
+		currentIndex: entry.Index,
+		commitIndex:  commitIndex,
+	}
+	return applier.Apply(ctx)
+}
+
+func (s *server) Name() string { return s.name }
+
+func (s *server) State() State {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.state
+}
+
+func (s *server) setState(state State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state = state
+}
+
+func (s *server) Leader() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.leader
+}
+
+func (s *server) CurrentTerm() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.currentTerm
+}
+
+func (s *server) Peers() map[string]*Peer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	peers := make(map[string]*Peer, len(s.peers))
+	for name, peer := range s.peers {
+		peers[name] = peer.clone()
+	}
+	return peers
+}
+
+// Storage returns the Storage backing this server's log, for callers that
+// want to read entries or snapshots directly (e.g. an operator tool).
+func (s *server) Storage() Storage {
+	return s.log
+}
+
+// setCurrentTerm transitions to a new term (clearing this server's vote),
+// optionally recording who leads it, and, when stepDown is set, steps down
+// to Follower if it was Leader or Candidate.
+func (s *server) setCurrentTerm(term uint64, leader string, stepDown bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if term > s.currentTerm {
+		s.currentTerm = term
+		s.votedFor = ""
+	}
+	if leader != "" {
+		s.leader = leader
+	}
+	if stepDown && s.state != Follower {
+		s.state = Follower
+	}
+	s.log.SetHardState(HardState{Term: s.currentTerm, VotedFor: s.votedFor, CommitIndex: s.log.getCommitIndex()})
+}
+
+// Start brings the server up as a Follower and begins its election timer.
+func (s *server) Start() error {
+	s.mutex.Lock()
+	if s.state != Stopped && s.state != Initialized {
+		s.mutex.Unlock()
+		return fmt.Errorf("raft.Server: Server already running")
+	}
+
+	hs, err := s.log.InitialState()
+	if err == nil {
+		s.currentTerm = hs.Term
+		s.votedFor = hs.VotedFor
+	}
+
+	s.state = Follower
+	s.stopChan = make(chan bool)
+	s.mutex.Unlock()
+
+	go s.electionTimeoutLoop()
+	return nil
+}
+
+// Stop halts the server's background loops and heartbeats.
+func (s *server) Stop() {
+	s.mutex.Lock()
+	if s.state == Stopped {
+		s.mutex.Unlock()
+		return
+	}
+	s.state = Stopped
+	close(s.stopChan)
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mutex.Unlock()
+
+	for _, p := range peers {
+		p.stopHeartbeat(false)
+	}
+	s.log.close()
+}
+
+// ForceNewCluster recovers this server as the leader of a brand new,
+// single-member cluster consisting of just itself, for use when a majority
+// of peers has been permanently lost and there is no other way to restore
+// a working quorum. It discards every other member from the configuration,
+// bumps the term so no former peer can mistake this node for a continuation
+// of the old cluster, and starts it directly as Leader rather than waiting
+// on an election (there is nobody left to vote). Once it is serving,
+// surviving peers can be folded into the new cluster with AddPeer.
+//
+// This repo tracks cluster membership only in a snapshot's ConfState, not
+// as log entries, so there is no separate log rewrite to perform beyond
+// what taking a snapshot already does: ForceNewCluster snapshots at the
+// log's last committed index (discarding any uncommitted tail the old
+// leader may have replicated here but never got to commit) and writes that
+// snapshot's ConfState as {self} alone.
+//
+// The server must be Stopped (e.g. freshly created, or previously
+// Stop()ped) when this is called; ForceNewCluster starts it, so callers
+// must not also call Start.
+func (s *server) ForceNewCluster() error {
+	s.mutex.Lock()
+	if s.state != Stopped && s.state != Initialized {
+		s.mutex.Unlock()
+		return ErrAlreadyRunning
+	}
+	s.peers = make(map[string]*Peer)
+	s.mutex.Unlock()
+
+	hs, err := s.log.InitialState()
+	if err != nil {
+		return err
+	}
+
+	index := s.log.getCommitIndex()
+	entryTerm, err := s.log.Term(index)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if s.stateMachine != nil {
+		data, err = s.stateMachine.Save()
+		if err != nil {
+			return err
+		}
+	}
+	snap := Snapshot{
+		Metadata: SnapshotMetadata{
+			Index:     index,
+			Term:      entryTerm,
+			ConfState: ConfState{Peers: []ConfPeer{{Name: s.name}}},
+		},
+		Data: data,
+	}
+	if err := s.log.ApplySnapshot(snap); err != nil {
+		return err
+	}
+
+	newTerm := hs.Term + 1
+	s.mutex.Lock()
+	s.currentTerm = newTerm
+	s.votedFor = s.name
+	s.mutex.Unlock()
+	s.log.SetHardState(HardState{Term: newTerm, VotedFor: s.name, CommitIndex: index})
+
+	if err := s.Start(); err != nil {
+		return err
+	}
+	s.becomeLeader()
+	return nil
+}
+
+// electionTimeoutLoop fires a new election any time this server goes too
+// long without hearing from a leader.
+func (s *server) electionTimeoutLoop() {
+	for {
+		timeout := s.electionTimeout + time.Duration(rand.Int63n(int64(s.electionTimeout)))
+		select {
+		case <-s.stopChan:
+			return
+		case <-time.After(timeout):
+		}
+
+		if s.State() == Leader {
+			continue
+		}
+		s.runElection()
+	}
+}
+
+// runElection transitions this server to Candidate and solicits votes from
+// its peers, becoming Leader if it wins a majority.
+func (s *server) runElection() {
+	s.mutex.Lock()
+	s.state = Candidate
+	s.currentTerm++
+	s.votedFor = s.name
+	term := s.currentTerm
+	lastIndex := s.log.currentIndex()
+	lastTerm := s.log.currentTerm()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mutex.Unlock()
+
+	votes := 1 // vote for self
+	var voteMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *Peer) {
+			defer wg.Done()
+			req := &RequestVoteRequest{Term: term, LastLogIndex: lastIndex, LastLogTerm: lastTerm, CandidateName: s.name}
+			resp := s.transporter.SendVoteRequest(s, p, req)
+			if resp == nil {
+				return
+			}
+			if resp.Term > term {
+				s.setCurrentTerm(resp.Term, "", true)
+				return
+			}
+			if resp.VoteGranted {
+				voteMutex.Lock()
+				votes++
+				voteMutex.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if s.State() != Candidate || s.CurrentTerm() != term {
+		return
+	}
+	if votes > (len(peers)+1)/2 {
+		s.becomeLeader()
+	}
+}
+
+// becomeLeader transitions this server to Leader and starts heartbeating
+// every peer.
+func (s *server) becomeLeader() {
+	s.mutex.Lock()
+	s.state = Leader
+	s.leader = s.name
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		p.setPrevLogIndex(s.log.currentIndex())
+		peers = append(peers, p)
+	}
+	s.mutex.Unlock()
+
+	for _, p := range peers {
+		p.startHeartbeat()
+	}
+
+	// Commit a no-op entry from this term, per the Raft paper §5.4.2, so
+	// reads are safe as soon as it commits.
+	s.Do(&NOPCommand{})
+}
+
+// Do appends command to the log, waits for it to commit, and returns
+// whatever its Apply method returned.
+func (s *server) Do(command Command) (interface{}, error) {
+	if s.State() == Stopped {
+		return nil, ErrStopped
+	}
+	if s.State() != Leader {
+		return nil, ErrNotLeader
+	}
+
+	s.mutex.Lock()
+	term := s.currentTerm
+	index := s.log.currentIndex() + 1
+	s.mutex.Unlock()
+
+	event := &ev{c: make(chan error, 1)}
+	entry, err := newLogEntry(s.log, event, index, term, command)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.log.appendEntry(entry); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mutex.RUnlock()
+
+	if len(peers) == 0 {
+		if err := s.log.setCommitIndex(index); err != nil {
+			return nil, err
+		}
+		// setCommitIndex already applied the entry synchronously above and
+		// buffered its error onto event.c; read it back rather than
+		// applying the command a second time here.
+		if err := <-event.c; err != nil {
+			return nil, err
+		}
+	} else {
+		// Push the new entry out immediately rather than waiting for the
+		// next heartbeat tick. This races with each peer's heartbeat
+		// goroutine, which is exactly the concurrency the snapshot
+		// transfer state machine in Peer guards against.
+		for _, p := range peers {
+			go p.flush()
+		}
+
+		select {
+		case err := <-event.c:
+			if err != nil {
+				return nil, err
+			}
+		case <-time.After(5 * time.Second):
+			return nil, ErrCommandTimeout
+		}
+	}
+
+	return event.result, nil
+}
+
+// advanceCommitIndex recomputes the highest index a majority of the
+// cluster (this server plus its peers) has replicated, and advances the
+// log's commit index to match. Per the Raft paper §5.4.2, a leader only
+// ever commits an entry this way if it was proposed in its current term;
+// older-term entries are committed as a side effect of a newer entry
+// committing.
+func (s *server) advanceCommitIndex() {
+	s.mutex.RLock()
+	if s.state != Leader {
+		s.mutex.RUnlock()
+		return
+	}
+	term := s.currentTerm
+	indices := make([]uint64, 0, len(s.peers)+1)
+	indices = append(indices, s.log.currentIndex())
+	for _, p := range s.peers {
+		indices = append(indices, p.getMatchIndex())
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	majorityIndex := indices[(len(indices)-1)/2]
+
+	if majorityIndex <= s.log.getCommitIndex() {
+		return
+	}
+	if entryTerm, err := s.log.Term(majorityIndex); err != nil || entryTerm != term {
+		return
+	}
+	s.log.setCommitIndex(majorityIndex)
+}
+
+// AddPeer registers a new member of the cluster and, if this server is the
+// current leader, starts heartbeating it immediately.
+func (s *server) AddPeer(name string, connectionString string) error {
+	if name == s.name {
+		return fmt.Errorf("raft.Server: Cannot add self as peer")
+	}
+
+	s.mutex.Lock()
+	if _, ok := s.peers[name]; ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("raft.Server: Peer already exists: %s", name)
+	}
+	peer := newPeer(s, name, connectionString, s.heartbeatInterval)
+	s.peers[name] = peer
+	isLeader := s.state == Leader
+	s.mutex.Unlock()
+
+	if isLeader {
+		peer.startHeartbeat()
+	}
+	return nil
+}
+
+// RemovePeer removes a member from the cluster, stopping its heartbeat if
+// one is running.
+func (s *server) RemovePeer(name string) error {
+	s.mutex.Lock()
+	peer, ok := s.peers[name]
+	if !ok {
+		s.mutex.Unlock()
+		return fmt.Errorf("raft.Server: Peer not found: %s", name)
+	}
+	delete(s.peers, name)
+	s.mutex.Unlock()
+
+	peer.stopHeartbeat(false)
+	return nil
+}
+
+// RequestVote handles an incoming RequestVoteRequest from a candidate.
+func (s *server) RequestVote(req *RequestVoteRequest) *RequestVoteResponse {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if req.Term < s.currentTerm {
+		return &RequestVoteResponse{Term: s.currentTerm, VoteGranted: false}
+	}
+	if req.Term > s.currentTerm {
+		s.currentTerm = req.Term
+		s.votedFor = ""
+		s.state = Follower
+	}
+
+	lastIndex := s.log.currentIndex()
+	lastTerm := s.log.currentTerm()
+	logUpToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= lastIndex)
+
+	if (s.votedFor == "" || s.votedFor == req.CandidateName) && logUpToDate {
+		s.votedFor = req.CandidateName
+		return &RequestVoteResponse{Term: s.currentTerm, VoteGranted: true}
+	}
+	return &RequestVoteResponse{Term: s.currentTerm, VoteGranted: false}
+}
+
+// AppendEntries handles an incoming AppendEntriesRequest from the leader.
+func (s *server) AppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	s.mutex.Lock()
+
+	if req.Term < s.currentTerm {
+		term := s.currentTerm
+		s.mutex.Unlock()
+		return &AppendEntriesResponse{Term: term, Success: false}
+	}
+
+	s.currentTerm = req.Term
+	s.leader = req.LeaderName
+	s.state = Follower
+	s.mutex.Unlock()
+
+	if req.PrevLogIndex > 0 {
+		term, err := s.log.Term(req.PrevLogIndex)
+		if err != nil || term != req.PrevLogTerm {
+			return &AppendEntriesResponse{Term: s.CurrentTerm(), Success: false}
+		}
+	}
+
+	for _, entry := range req.Entries {
+		entry.log = s.log
+		if err := s.log.appendEntry(entry); err != nil {
+			return &AppendEntriesResponse{Term: s.CurrentTerm(), Success: false}
+		}
+	}
+
+	if req.CommitIndex > 0 {
+		s.log.setCommitIndex(req.CommitIndex)
+	}
+
+	return &AppendEntriesResponse{Term: s.CurrentTerm(), Index: s.log.currentIndex(), CommitIndex: s.log.getCommitIndex(), Success: true}
+}
+
+// RequestSnapshot handles an incoming SnapshotRequest, telling the leader
+// whether this server actually needs the snapshot it's offering.
+func (s *server) RequestSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	if req.LastIndex <= s.log.currentIndex() {
+		return &SnapshotResponse{Success: false}
+	}
+	return &SnapshotResponse{Success: true}
+}
+
+// SnapshotRecovery handles one chunk of an incoming InstallSnapshot
+// transfer, buffering it until Done arrives, at which point the full
+// snapshot is installed as this server's new base state. A chunk whose
+// Offset doesn't match what's been received so far is treated as a
+// retransmit of something already applied (or a stale chunk from an
+// abandoned transfer); NextOffset in the response tells the sender where
+// this server actually is so it can resume correctly.
+func (s *server) SnapshotRecovery(req *SnapshotRecoveryRequest) *SnapshotRecoveryResponse {
+	s.mutex.Lock()
+	if s.incoming == nil || s.incoming.metadata.Index != req.Metadata.Index || s.incoming.metadata.Term != req.Metadata.Term {
+		s.incoming = &incomingSnapshot{metadata: req.Metadata}
+	}
+
+	if uint64(len(s.incoming.data)) != req.Offset {
+		nextOffset := uint64(len(s.incoming.data))
+		s.mutex.Unlock()
+		return &SnapshotRecoveryResponse{Term: s.CurrentTerm(), Success: true, NextOffset: nextOffset}
+	}
+
+	s.incoming.data = append(s.incoming.data, req.Data...)
+	nextOffset := uint64(len(s.incoming.data))
+
+	if !req.Done {
+		s.mutex.Unlock()
+		return &SnapshotRecoveryResponse{Term: s.CurrentTerm(), Success: true, NextOffset: nextOffset}
+	}
+
+	snap := Snapshot{Metadata: req.Metadata, Data: s.incoming.data}
+	s.incoming = nil
+	s.mutex.Unlock()
+
+	if err := s.log.ApplySnapshot(snap); err != nil {
+		return &SnapshotRecoveryResponse{Term: s.CurrentTerm(), Success: false}
+	}
+	if s.stateMachine != nil {
+		if err := s.stateMachine.Recovery(snap.Data); err != nil {
+			return &SnapshotRecoveryResponse{Term: s.CurrentTerm(), Success: false}
+		}
+	}
+	return &SnapshotRecoveryResponse{Term: s.CurrentTerm(), Success: true, CommitIndex: s.log.getCommitIndex(), NextOffset: nextOffset}
+}
+
+// TakeSnapshot captures the current state machine state, hands it to the
+// log's Storage (the file-backed default persists it to disk), and compacts
+// the log up to the current commit index.
+func (s *server) TakeSnapshot() error {
+	if s.stateMachine == nil {
+		return fmt.Errorf("raft.Server: No state machine configured")
+	}
+
+	commitIndex := s.log.getCommitIndex()
+	term, err := s.log.Term(commitIndex)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.stateMachine.Save()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.RLock()
+	peers := make([]ConfPeer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, ConfPeer{Name: p.Name, ConnectionString: p.ConnectionString})
+	}
+	s.mutex.RUnlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Name < peers[j].Name })
+
+	snap := Snapshot{
+		Metadata: SnapshotMetadata{
+			Index:     commitIndex,
+			Term:      term,
+			ConfState: ConfState{Peers: peers},
+		},
+		Data: data,
+	}
+	return s.log.Compact(snap)
+}
@@ -0,0 +1,63 @@
+package raft
+
+import "testing"
+
+// countingStateMachine counts how many times Apply has been invoked across
+// every command it's given, so a test can catch a command being applied
+// more than once for a single commit.
+type countingStateMachine struct {
+	applies int
+}
+
+func (sm *countingStateMachine) Save() ([]byte, error)      { return nil, nil }
+func (sm *countingStateMachine) Recovery(data []byte) error { return nil }
+
+type countingCommand struct{}
+
+func (c *countingCommand) CommandName() string { return "test:command:counting" }
+
+func (c *countingCommand) Apply(context Context) (interface{}, error) {
+	sm := context.Server().(*server).stateMachine.(*countingStateMachine)
+	sm.applies++
+	return sm.applies, nil
+}
+
+func init() {
+	RegisterCommand(&countingCommand{})
+}
+
+// TestDoAppliesCommandExactlyOnce guards against Do applying a committed
+// entry a second time on top of the apply setCommitIndex already performs
+// while advancing the commit index: with no peers, a single Do() call
+// must result in exactly one Apply, and the value Do() returns must be
+// what that single Apply produced.
+func TestDoAppliesCommandExactlyOnce(t *testing.T) {
+	sm := &countingStateMachine{}
+	srv, err := NewServerWithStorage("node1", NewMemoryStorage(), nil, sm, "")
+	if err != nil {
+		t.Fatalf("Unable to create server: %v", err)
+	}
+	s := srv.(*server)
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Unable to start server: %v", err)
+	}
+	defer s.Stop()
+
+	s.setState(Leader)
+	s.mutex.Lock()
+	s.currentTerm = 1
+	s.leader = s.name
+	s.mutex.Unlock()
+
+	result, err := s.Do(&countingCommand{})
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if sm.applies != 1 {
+		t.Fatalf("expected Apply to run exactly once, ran %d times", sm.applies)
+	}
+	if result != 1 {
+		t.Fatalf("expected Do to return the single Apply's result (1), got %v", result)
+	}
+}
@@ -0,0 +1,130 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// ConfPeer is a single cluster member as recorded in a snapshot's
+// ConfState: enough to let a node that installs the snapshot rejoin the
+// cluster without a separate discovery step.
+type ConfPeer struct {
+	Name             string
+	ConnectionString string
+}
+
+// ConfState captures cluster membership at the point a snapshot was taken.
+type ConfState struct {
+	Peers []ConfPeer
+}
+
+// SnapshotMetadata is the Raft bookkeeping that travels with a snapshot:
+// the index and term of the last entry it covers, and the membership in
+// effect at that point.
+type SnapshotMetadata struct {
+	Index     uint64
+	Term      uint64
+	ConfState ConfState
+}
+
+// Snapshot is a point-in-time copy of a server's state machine. Data is an
+// opaque payload produced by StateMachine.Save and is only ever passed back
+// to StateMachine.Recovery; this package never looks inside it.
+type Snapshot struct {
+	Metadata SnapshotMetadata
+	Data     []byte
+}
+
+// snapshotMagic identifies a versioned on-disk snapshot file, as opposed to
+// the unversioned, header-less encoding used before this format existed.
+var snapshotMagic = [4]byte{'R', 'S', 'N', 'P'}
+
+// snapshotVersion1 is the current on-disk snapshot format: magic, a single
+// version byte, then the JSON encoding of Snapshot. Bumping this lets future
+// changes (streaming/chunked snapshots, compression) be introduced without
+// breaking readers of the current format, since they can dispatch on the
+// version byte before deciding how to parse what follows.
+const snapshotVersion1 byte = 1
+
+// legacySnapshot is the shape Snapshot had before it grew a Metadata
+// sub-struct. It is only used to upgrade pre-existing, unversioned snapshot
+// files written by older versions of this package.
+type legacySnapshot struct {
+	LastIndex uint64
+	LastTerm  uint64
+	Peers     []legacyPeer
+	State     []byte
+}
+
+type legacyPeer struct {
+	Name             string
+	ConnectionString string
+}
+
+// writeSnapshot serializes snap to path in the current versioned format.
+func writeSnapshot(path string, snap Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	buf.WriteByte(snapshotVersion1)
+	buf.Write(data)
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// readSnapshotFile reads the snapshot at path, transparently upgrading it
+// if it was written in the pre-Metadata, unversioned format.
+func readSnapshotFile(path string) (Snapshot, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(raw) == 0 {
+		return Snapshot{}, nil
+	}
+
+	if len(raw) >= 5 && bytes.Equal(raw[:4], snapshotMagic[:]) {
+		switch version := raw[4]; version {
+		case snapshotVersion1:
+			var snap Snapshot
+			if err := json.Unmarshal(raw[5:], &snap); err != nil {
+				return Snapshot{}, err
+			}
+			return snap, nil
+		default:
+			return Snapshot{}, fmt.Errorf("raft: unsupported snapshot format version %d", version)
+		}
+	}
+
+	// No recognized magic header: this must be a snapshot written before
+	// the versioned format existed. Upgrade it in place.
+	var legacy legacySnapshot
+	if err := json.Unmarshal(raw, &legacy); err != nil {
+		return Snapshot{}, fmt.Errorf("raft: unrecognized snapshot file %s: %v", path, err)
+	}
+
+	peers := make([]ConfPeer, len(legacy.Peers))
+	for i, p := range legacy.Peers {
+		peers[i] = ConfPeer{Name: p.Name, ConnectionString: p.ConnectionString}
+	}
+
+	snap := Snapshot{
+		Metadata: SnapshotMetadata{
+			Index:     legacy.LastIndex,
+			Term:      legacy.LastTerm,
+			ConfState: ConfState{Peers: peers},
+		},
+		Data: legacy.State,
+	}
+
+	if err := writeSnapshot(path, snap); err != nil {
+		return Snapshot{}, fmt.Errorf("raft: upgrading legacy snapshot %s: %v", path, err)
+	}
+	return snap, nil
+}
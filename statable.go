@@ -0,0 +1,9 @@
+package raft
+
+// StateMachine is implemented by the application state a Server replicates.
+// Save is called to produce a snapshot payload, and Recovery to restore one
+// received from a leader (or read back from disk on restart).
+type StateMachine interface {
+	Save() ([]byte, error)
+	Recovery(data []byte) error
+}
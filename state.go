@@ -0,0 +1,14 @@
+package raft
+
+// State represents the role a server currently occupies within the
+// cluster's Raft state machine.
+type State string
+
+const (
+	Stopped      State = "stopped"
+	Initialized  State = "initialized"
+	Follower     State = "follower"
+	Candidate    State = "candidate"
+	Leader       State = "leader"
+	Snapshotting State = "snapshotting"
+)
@@ -0,0 +1,250 @@
+package raft
+
+import "sync"
+
+// HardState holds the Raft state that must survive a restart: the term and
+// candidate a server last voted for, plus the highest log index it knows to
+// be committed.
+type HardState struct {
+	Term        uint64
+	VotedFor    string
+	CommitIndex uint64
+}
+
+// IsEmpty returns true for the zero value, i.e. a server that has never
+// persisted any state.
+func (hs HardState) IsEmpty() bool {
+	return hs.Term == 0 && hs.VotedFor == "" && hs.CommitIndex == 0
+}
+
+// Storage is the interface a Server and its Peers use to read and persist
+// log entries, hard state and snapshots. It exists so that callers can swap
+// in their own durable backend (BoltDB, LevelDB, an in-memory store for
+// tests, ...) without forking this package, mirroring the storage split in
+// etcd's raft library.
+//
+// Entries and Term report ErrCompacted when the
+// requested index has already been discarded by a compaction, and
+// ErrUnavailable when the requested index is past what the implementation
+// currently has (the caller has raced ahead, e.g. with a stale
+// prevLogIndex).
+type Storage interface {
+	// InitialState returns the HardState last persisted via SetHardState.
+	InitialState() (HardState, error)
+
+	// Entries returns the log entries in the half-open range [lo, hi). An
+	// inverted range (lo > hi) reports ErrUnavailable rather than ever
+	// being given to an implementation to index with. maxSize bounds the
+	// total serialized size of the returned entries; at least one entry is
+	// always returned if one exists in range.
+	Entries(lo, hi, maxSize uint64) ([]*LogEntry, error)
+
+	// Term returns the term of the entry at index i.
+	Term(i uint64) (uint64, error)
+
+	// LastIndex returns the index of the last entry in the log.
+	LastIndex() (uint64, error)
+
+	// FirstIndex returns the index of the first entry still available
+	// (i.e. one past the index covered by the most recent snapshot).
+	FirstIndex() (uint64, error)
+
+	// Snapshot returns the most recently applied snapshot, if any.
+	Snapshot() (Snapshot, error)
+
+	// ApplySnapshot makes the given snapshot the storage's base state,
+	// unconditionally discarding every entry currently held: it is for
+	// installing a snapshot whose lineage isn't already known to match
+	// what's here, e.g. a follower receiving InstallSnapshot from its
+	// leader, or a forced single-member recovery discarding an
+	// uncommitted tail. Callers compacting their own, already-trusted log
+	// (taking a snapshot of entries already known committed here) should
+	// use Compact instead, which keeps anything newer.
+	ApplySnapshot(snap Snapshot) error
+
+	// Compact discards every entry up to and including snap.Metadata.Index,
+	// recording snap as the new base, but preserves any entries already
+	// held beyond that index. Unlike ApplySnapshot, it never discards
+	// entries the caller didn't ask to compact away.
+	Compact(snap Snapshot) error
+
+	// SetHardState persists hs so that it is returned by a future
+	// InitialState call, including across a restart.
+	SetHardState(hs HardState) error
+
+	// Append appends entries to the log. It is the caller's responsibility
+	// to ensure entries are contiguous with what is already stored.
+	Append(entries []*LogEntry) error
+}
+
+// MemoryStorage is a Storage implementation backed by an in-memory slice.
+// It is intended for tests and for embedders that don't need entries to
+// survive a process restart.
+type MemoryStorage struct {
+	mutex sync.RWMutex
+
+	hardState HardState
+	snapshot  Snapshot
+
+	// entries[i] holds the log entry for index i+entries[0].Index, so the
+	// first real entry, entries[1], is at firstIndex(). entries[0] is
+	// always a placeholder carrying the index and term of the last
+	// compacted entry (i.e. snapshot.Metadata.Index and its term), playing
+	// the role of the startIndex/startTerm pair the file-backed storage
+	// keeps.
+	entries []*LogEntry
+}
+
+// NewMemoryStorage creates an empty, ready-to-use MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		entries: []*LogEntry{{Index: 0, Term: 0}},
+	}
+}
+
+func (ms *MemoryStorage) InitialState() (HardState, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.hardState, nil
+}
+
+func (ms *MemoryStorage) firstIndex() uint64 {
+	return ms.entries[0].Index + 1
+}
+
+func (ms *MemoryStorage) lastIndex() uint64 {
+	return ms.entries[0].Index + uint64(len(ms.entries)) - 1
+}
+
+func (ms *MemoryStorage) FirstIndex() (uint64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.firstIndex(), nil
+}
+
+func (ms *MemoryStorage) LastIndex() (uint64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.lastIndex(), nil
+}
+
+func (ms *MemoryStorage) Term(i uint64) (uint64, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	offset := ms.entries[0].Index
+	if i < offset {
+		return 0, ErrCompacted
+	}
+	if int(i-offset) >= len(ms.entries) {
+		return 0, ErrUnavailable
+	}
+	return ms.entries[i-offset].Term, nil
+}
+
+func (ms *MemoryStorage) Entries(lo, hi, maxSize uint64) ([]*LogEntry, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if lo > hi {
+		return nil, ErrUnavailable
+	}
+	offset := ms.entries[0].Index
+	if lo <= offset {
+		return nil, ErrCompacted
+	}
+	if hi > ms.lastIndex()+1 {
+		return nil, ErrUnavailable
+	}
+
+	ents := ms.entries[lo-offset : hi-offset]
+	return limitEntriesBySize(ents, maxSize), nil
+}
+
+func (ms *MemoryStorage) Snapshot() (Snapshot, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.snapshot, nil
+}
+
+func (ms *MemoryStorage) ApplySnapshot(snap Snapshot) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.snapshot = snap
+	ms.entries = []*LogEntry{{Index: snap.Metadata.Index, Term: snap.Metadata.Term}}
+	return nil
+}
+
+func (ms *MemoryStorage) Compact(snap Snapshot) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	index, term := snap.Metadata.Index, snap.Metadata.Term
+	offset := ms.entries[0].Index
+	if index <= offset {
+		return nil
+	}
+
+	if index <= ms.lastIndex() {
+		ms.entries = append([]*LogEntry{{Index: index, Term: term}}, ms.entries[index-offset+1:]...)
+	} else {
+		ms.entries = []*LogEntry{{Index: index, Term: term}}
+	}
+	ms.snapshot = snap
+	return nil
+}
+
+func (ms *MemoryStorage) SetHardState(hs HardState) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.hardState = hs
+	return nil
+}
+
+func (ms *MemoryStorage) Append(entries []*LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	first := ms.entries[0].Index + 1
+	last := entries[0].Index + uint64(len(entries)) - 1
+	if last < first {
+		return nil
+	}
+	if first > entries[0].Index {
+		entries = entries[first-entries[0].Index:]
+	}
+
+	offset := entries[0].Index - ms.entries[0].Index
+	switch {
+	case uint64(len(ms.entries)) > offset:
+		ms.entries = append([]*LogEntry{}, ms.entries[:offset]...)
+		ms.entries = append(ms.entries, entries...)
+	case uint64(len(ms.entries)) == offset:
+		ms.entries = append(ms.entries, entries...)
+	default:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// limitEntriesBySize trims ents so that the cumulative size of the returned
+// slice does not exceed maxSize, always keeping at least the first entry.
+func limitEntriesBySize(ents []*LogEntry, maxSize uint64) []*LogEntry {
+	if len(ents) == 0 || maxSize == 0 {
+		return ents
+	}
+	size := uint64(ents[0].size())
+	var i int
+	for i = 1; i < len(ents); i++ {
+		size += uint64(ents[i].size())
+		if size > maxSize {
+			break
+		}
+	}
+	return ents[:i]
+}
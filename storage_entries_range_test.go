@@ -0,0 +1,42 @@
+package raft
+
+import (
+	"os"
+	"testing"
+)
+
+// TestEntriesRejectsInvertedRange guards Log.Entries (file-backed) and
+// MemoryStorage.Entries against an inverted range (lo > hi): slicing
+// entries[lo:hi] with lo past hi panics rather than returning an error,
+// and a stale or miscomputed prevLogIndex can produce exactly that.
+func TestEntriesRejectsInvertedRange(t *testing.T) {
+	path := getLogPath()
+	defer os.Remove(path)
+	defer os.Remove(path + ".snapshot")
+
+	log, err := newFileLog(path)
+	if err != nil {
+		t.Fatalf("Unable to open log: %v", err)
+	}
+	defer log.close()
+
+	for i := uint64(1); i <= 10; i++ {
+		if err := log.appendEntry(&LogEntry{Index: i, Term: 1}); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+
+	if _, err := log.Entries(8, 3, 0); err != ErrUnavailable {
+		t.Fatalf("expected ErrUnavailable for an inverted range from the file-backed Log, got %v", err)
+	}
+
+	storage := NewMemoryStorage()
+	for i := uint64(1); i <= 10; i++ {
+		if err := storage.Append([]*LogEntry{{Index: i, Term: 1}}); err != nil {
+			t.Fatalf("Unable to append entry %d: %v", i, err)
+		}
+	}
+	if _, err := storage.Entries(8, 3, 0); err != ErrUnavailable {
+		t.Fatalf("expected ErrUnavailable for an inverted range from MemoryStorage, got %v", err)
+	}
+}
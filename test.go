@@ -0,0 +1,123 @@
+package raft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// getLogPath returns a path to a unique, non-existent file in a temp
+// directory, suitable for a test to open a Log against.
+func getLogPath() string {
+	f, err := ioutil.TempFile("", "raft-log-")
+	if err != nil {
+		panic(err)
+	}
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+	return path
+}
+
+// testCommand1 and testCommand2 are Commands used only by this package's
+// own tests.
+type testCommand1 struct {
+	Val string `json:"val"`
+	I   int    `json:"i"`
+}
+
+func (c *testCommand1) CommandName() string {
+	return "test:command:1"
+}
+
+func (c *testCommand1) Apply(context Context) (interface{}, error) {
+	return fmt.Sprintf("%s:%d", c.Val, c.I), nil
+}
+
+type testCommand2 struct {
+	X int `json:"x"`
+}
+
+func (c *testCommand2) CommandName() string {
+	return "test:command:2"
+}
+
+func (c *testCommand2) Apply(context Context) (interface{}, error) {
+	return c.X, nil
+}
+
+// testIncrementCommand increments the applying server's state machine Count
+// field by one, for tests that need to tell whether a command actually
+// reached the state machine — rather than merely surviving in the log —
+// e.g. across a restart. It only works against a *testStateMachine, which
+// is all this package's tests ever configure.
+type testIncrementCommand struct{}
+
+func (c *testIncrementCommand) CommandName() string {
+	return "test:command:increment"
+}
+
+func (c *testIncrementCommand) Apply(context Context) (interface{}, error) {
+	sm := context.Server().(*server).stateMachine.(*testStateMachine)
+	sm.Count++
+	return sm.Count, nil
+}
+
+func init() {
+	RegisterCommand(&testCommand1{})
+	RegisterCommand(&testCommand2{})
+	RegisterCommand(&testIncrementCommand{})
+}
+
+// testStateMachine is a trivial StateMachine used by tests that exercise
+// snapshotting: it just round-trips a counter through JSON.
+type testStateMachine struct {
+	Count int
+}
+
+func (sm *testStateMachine) Save() ([]byte, error) {
+	return json.Marshal(sm)
+}
+
+func (sm *testStateMachine) Recovery(data []byte) error {
+	return json.Unmarshal(data, sm)
+}
+
+// fakeTransporter routes RPCs directly between in-process servers by name,
+// so peer/server tests don't need a real network transport.
+type fakeTransporter struct {
+	servers map[string]Server
+}
+
+func (t *fakeTransporter) SendVoteRequest(server Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse {
+	target, ok := t.servers[peer.Name]
+	if !ok {
+		return nil
+	}
+	return target.RequestVote(req)
+}
+
+func (t *fakeTransporter) SendAppendEntriesRequest(server Server, peer *Peer, req *AppendEntriesRequest) *AppendEntriesResponse {
+	target, ok := t.servers[peer.Name]
+	if !ok {
+		return nil
+	}
+	return target.AppendEntries(req)
+}
+
+func (t *fakeTransporter) SendSnapshotRequest(server Server, peer *Peer, req *SnapshotRequest) *SnapshotResponse {
+	target, ok := t.servers[peer.Name]
+	if !ok {
+		return nil
+	}
+	return target.RequestSnapshot(req)
+}
+
+func (t *fakeTransporter) SendSnapshotRecoveryRequest(server Server, peer *Peer, req *SnapshotRecoveryRequest) *SnapshotRecoveryResponse {
+	target, ok := t.servers[peer.Name]
+	if !ok {
+		return nil
+	}
+	return target.SnapshotRecovery(req)
+}
@@ -0,0 +1,82 @@
+package raft
+
+// Transporter is implemented by whatever carries RPCs between the members
+// of a cluster (HTTP, gRPC, an in-process channel for tests, ...).
+type Transporter interface {
+	SendVoteRequest(server Server, peer *Peer, req *RequestVoteRequest) *RequestVoteResponse
+	SendAppendEntriesRequest(server Server, peer *Peer, req *AppendEntriesRequest) *AppendEntriesResponse
+	SendSnapshotRequest(server Server, peer *Peer, req *SnapshotRequest) *SnapshotResponse
+	SendSnapshotRecoveryRequest(server Server, peer *Peer, req *SnapshotRecoveryRequest) *SnapshotRecoveryResponse
+}
+
+// RequestVoteRequest is sent by a candidate to solicit votes from its peers.
+type RequestVoteRequest struct {
+	Term          uint64
+	LastLogIndex  uint64
+	LastLogTerm   uint64
+	CandidateName string
+}
+
+// RequestVoteResponse is a peer's answer to a RequestVoteRequest.
+type RequestVoteResponse struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesRequest replicates a batch of log entries (or serves as a
+// heartbeat when Entries is empty) from leader to follower.
+type AppendEntriesRequest struct {
+	Term         uint64
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	CommitIndex  uint64
+	LeaderName   string
+	Entries      []*LogEntry
+}
+
+// AppendEntriesResponse is a follower's answer to an AppendEntriesRequest.
+type AppendEntriesResponse struct {
+	Term        uint64
+	Index       uint64
+	CommitIndex uint64
+	Success     bool
+}
+
+// SnapshotRequest asks a follower whether it is willing to receive a
+// snapshot, before the (potentially large) recovery payload is sent.
+type SnapshotRequest struct {
+	LeaderName string
+	LastIndex  uint64
+	LastTerm   uint64
+}
+
+// SnapshotResponse tells the leader whether to proceed with sending the
+// snapshot state.
+type SnapshotResponse struct {
+	Success bool
+}
+
+// SnapshotRecoveryRequest carries one chunk of a snapshot's Data, so that a
+// large snapshot can be streamed across several heartbeats instead of sent
+// as a single RPC. Metadata is repeated on every chunk so the receiver can
+// tell which transfer a chunk belongs to (and discard chunks left over from
+// an old, abandoned transfer).
+type SnapshotRecoveryRequest struct {
+	LeaderName string
+	Metadata   SnapshotMetadata
+	Offset     uint64
+	Data       []byte
+	Done       bool
+}
+
+// SnapshotRecoveryResponse is a follower's answer once it has processed a
+// SnapshotRecoveryRequest. NextOffset reports how many bytes of Data the
+// follower actually has for this transfer, which lets the sender resume
+// from the right place (e.g. after its own restart) instead of assuming
+// the chunk it just sent landed.
+type SnapshotRecoveryResponse struct {
+	Term        uint64
+	Success     bool
+	CommitIndex uint64
+	NextOffset  uint64
+}